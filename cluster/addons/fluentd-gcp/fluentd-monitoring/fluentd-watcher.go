@@ -0,0 +1,209 @@
+/*
+Copyright 2015 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/golang/glog"
+)
+
+// watchLogFiles watches baseLocations, and any subdirectory created under
+// them (e.g. a new container directory under /var/log/containers), for log
+// files being created or written to, and emits a logFileWasChanged
+// operation for each one. It probes baseLocations once up front, so files
+// already on disk are reported right away, and again every resyncInterval
+// to pick up changes whose events were missed, most commonly after an
+// IN_Q_OVERFLOW.
+func watchLogFiles(resyncInterval time.Duration, operations chan LogFileOperation, baseLocations []string) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		glog.Fatalf("Failed to create fsnotify watcher: %v", err)
+	}
+	defer watcher.Close()
+
+	for _, location := range baseLocations {
+		addWatchesRecursively(watcher, location)
+	}
+
+	emitLogFileOperation := func(absPath string) {
+		if filepath.Ext(absPath) != logFileExtension {
+			return
+		}
+
+		stat, err := os.Stat(absPath)
+		if err != nil {
+			glog.Warningf("Failed to stat file %s", absPath)
+			return
+		}
+
+		inode, device := inodeAndDevice(stat)
+
+		operations <- LogFileOperation{
+			FileName:      absPath,
+			FileSize:      stat.Size(),
+			Inode:         inode,
+			Device:        device,
+			OperationType: logFileWasChanged,
+		}
+	}
+
+	// Probe once up front, so files already on disk when this starts (the
+	// normal case) are reported immediately instead of waiting for the first
+	// resync tick.
+	for _, location := range baseLocations {
+		probeFiles([]string{location}, emitLogFileOperation)
+	}
+
+	resync := time.Tick(resyncInterval)
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+
+			switch {
+			case event.Op&fsnotify.Create != 0:
+				if isDir(event.Name) {
+					addWatchesRecursively(watcher, event.Name)
+				}
+				emitLogFileOperation(event.Name)
+			case event.Op&fsnotify.Write != 0:
+				emitLogFileOperation(event.Name)
+			case event.Op&(fsnotify.Rename|fsnotify.Remove) != 0:
+				// Ignore the error: the watch may already be gone if the
+				// parent directory was removed first.
+				watcher.Remove(event.Name)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			glog.Warningf("Log files watcher error: %v", err)
+		case <-resync:
+			for _, location := range baseLocations {
+				probeFiles([]string{location}, emitLogFileOperation)
+			}
+		}
+	}
+}
+
+// watchIngestedSizes watches baseLocation for position files (in parser's
+// format) being created or written to and emits a posFileWasChanged
+// operation for every entry they contain. Like watchLogFiles, it probes
+// baseLocation once up front and again every resyncInterval.
+func watchIngestedSizes(resyncInterval time.Duration, operations chan LogFileOperation, baseLocation string, parser PositionParser) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		glog.Fatalf("Failed to create fsnotify watcher: %v", err)
+	}
+	defer watcher.Close()
+
+	addWatchesRecursively(watcher, baseLocation)
+
+	emitPosFileOperations := func(absPath string) {
+		if filepath.Ext(absPath) != parser.Extension() {
+			return
+		}
+
+		entries, err := parser.Parse(absPath)
+		if err != nil {
+			glog.Warningf("Failed to parse position file %s: %v", absPath, err)
+			return
+		}
+
+		for _, entry := range entries {
+			operations <- LogFileOperation{
+				FileName:      entry.FileName,
+				FileSize:      entry.FileSize,
+				Inode:         entry.Inode,
+				OperationType: posFileWasChanged,
+			}
+		}
+	}
+
+	// Probe once up front, for the same reason as in watchLogFiles: position
+	// files already on disk shouldn't wait for the first resync tick.
+	probeFiles([]string{baseLocation}, emitPosFileOperations)
+
+	resync := time.Tick(resyncInterval)
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+
+			switch {
+			case event.Op&(fsnotify.Create|fsnotify.Write) != 0:
+				emitPosFileOperations(event.Name)
+			case event.Op&(fsnotify.Rename|fsnotify.Remove) != 0:
+				watcher.Remove(event.Name)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			glog.Warningf("Position files watcher error: %v", err)
+		case <-resync:
+			probeFiles([]string{baseLocation}, emitPosFileOperations)
+		}
+	}
+}
+
+// addWatchesRecursively adds a watch for root and, recursively, for every
+// subdirectory it currently contains. It's used both at startup and when a
+// new directory (e.g. a new container's log directory) is created.
+func addWatchesRecursively(watcher *fsnotify.Watcher, root string) {
+	if err := watcher.Add(root); err != nil {
+		glog.Warningf("Failed to watch %s: %v", root, err)
+		return
+	}
+
+	entries, err := ioutil.ReadDir(root)
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			addWatchesRecursively(watcher, filepath.Join(root, entry.Name()))
+		}
+	}
+}
+
+func isDir(path string) bool {
+	stat, err := os.Stat(path)
+	return err == nil && stat.IsDir()
+}
+
+// inodeAndDevice extracts the inode and device of a stat'd file, used to
+// detect log rotation (kubelet/docker renaming the file and recreating the
+// original path) independently of the path itself.
+func inodeAndDevice(stat os.FileInfo) (inode, device uint64) {
+	sys, ok := stat.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0
+	}
+	return sys.Ino, uint64(sys.Dev)
+}
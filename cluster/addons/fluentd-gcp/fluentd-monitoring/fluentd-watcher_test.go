@@ -0,0 +1,83 @@
+/*
+Copyright 2015 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestAddWatchesRecursively(t *testing.T) {
+	root := t.TempDir()
+	sub := filepath.Join(root, "containers")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatalf("Failed to create %s: %v", sub, err)
+	}
+	subsub := filepath.Join(sub, "nested")
+	if err := os.Mkdir(subsub, 0755); err != nil {
+		t.Fatalf("Failed to create %s: %v", subsub, err)
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatalf("Failed to create fsnotify watcher: %v", err)
+	}
+	defer watcher.Close()
+
+	addWatchesRecursively(watcher, root)
+
+	watched := map[string]bool{}
+	for _, path := range watcher.WatchList() {
+		watched[path] = true
+	}
+
+	for _, want := range []string{root, sub, subsub} {
+		if !watched[want] {
+			t.Errorf("addWatchesRecursively(%s) did not watch %s; watched: %v", root, want, watcher.WatchList())
+		}
+	}
+}
+
+func TestInodeAndDevice(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.log")
+	if err := ioutil.WriteFile(path, []byte("hello"), 0644); err != nil {
+		t.Fatalf("Failed to create %s: %v", path, err)
+	}
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat %s: %v", path, err)
+	}
+
+	inode, device := inodeAndDevice(stat)
+	if inode == 0 {
+		t.Errorf("inodeAndDevice() returned inode 0 for a real file")
+	}
+
+	stat2, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to re-stat %s: %v", path, err)
+	}
+	inode2, device2 := inodeAndDevice(stat2)
+	if inode != inode2 || device != device2 {
+		t.Errorf("inodeAndDevice() not stable across repeated stats: (%d, %d) != (%d, %d)", inode, device, inode2, device2)
+	}
+}
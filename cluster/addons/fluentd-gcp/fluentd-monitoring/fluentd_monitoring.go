@@ -20,23 +20,25 @@ import (
 	"flag"
 	"io/ioutil"
 	"net/http"
-	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"fmt"
 	"github.com/golang/glog"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	dto "github.com/prometheus/client_model/go"
 	"math"
 )
 
 var (
-	probeInterval = flag.Duration("probe-interval", 100*time.Millisecond, "Files probing interval")
-	port          = flag.Int("port", 1234, "Port on which to export metrics")
-	channelSize   = flag.Int("chan-size", 100*1000, "Size of operations channel")
+	probeInterval  = flag.Duration("probe-interval", 30*time.Second, "Fallback resync interval, used to catch file changes missed by the fsnotify watcher (e.g. after an IN_Q_OVERFLOW)")
+	port           = flag.Int("port", 1234, "Port on which to export metrics")
+	channelSize    = flag.Int("chan-size", 100*1000, "Size of operations channel")
+	positionFormat = flag.String("position-format", "auto", "Format of the log forwarder's position files: fluentd, fluent-bit, cri, or auto to detect by scanning the position files directory (/var/log)")
 
 	logFilesLocations = []string{
 		"/var/log",
@@ -44,22 +46,56 @@ var (
 	}
 	positionFilesLocation = "/var/log"
 
+	// k8sLogLabels are the labels added to logFileActualBytes,
+	// logFileIngestedBytes and logFileLostBytes on top of log_name, to
+	// identify the pod/container a log file belongs to. They're always
+	// declared, even when --enable-k8s-enrichment is off, since a GaugeVec's
+	// label set is fixed at creation; logNameLabels fills them with "" in
+	// that case.
+	k8sLogLabels = []string{"log_name", "pod", "namespace", "container", "container_id", "node"}
+
 	// Number of bytes in the log file
 	logFileActualBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "log_file_actual_bytes",
 		Help: "Actual size of log files",
-	}, []string{"log_name"})
+	}, k8sLogLabels)
 
 	// Number of bytes ingested by fluentd
 	logFileIngestedBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "log_file_ingested_bytes",
 		Help: "Number of bytes, ingested to fluentd",
-	}, []string{"log_name"})
+	}, k8sLogLabels)
 
 	// Number of bytes ingested by fluentd
 	logFileLostBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "log_file_lost_bytes",
 		Help: "Number of bytes, lost by fluentd",
+	}, k8sLogLabels)
+
+	// Number of bytes that were outstanding (not yet ingested) when a log
+	// file was rotated, i.e. data that the rotation-aware bookkeeping knows
+	// was never lost, just handed off to the rotated-away inode.
+	logFileRotatedBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "log_file_rotated_bytes",
+		Help: "Number of outstanding bytes handed off to a rotated-away log file",
+	}, []string{"log_name"})
+
+	// Time between a byte appearing in a log file and being picked up by
+	// fluentd, as observed via the position file. Ingestion lag can plausibly
+	// land anywhere across several orders of magnitude, so this uses a native
+	// (sparse) histogram instead of picking fixed bucket boundaries up front.
+	logFileIngestionLag = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:                           "log_file_ingestion_lag_seconds",
+		Help:                           "Lag between bytes appearing in a log file and being ingested by fluentd",
+		NativeHistogramBucketFactor:    1.1,
+		NativeHistogramMaxBucketNumber: 100,
+	}, []string{"log_name"})
+
+	// Number of pending offset observations evicted before a matching ingested
+	// offset was seen, because the per-file pending map hit pendingOffsetsLimit.
+	logFileIngestionLagEvicted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "log_file_ingestion_lag_evicted_total",
+		Help: "Number of pending ingestion-lag offset observations evicted due to a stalled ingester",
 	}, []string{"log_name"})
 )
 
@@ -69,103 +105,207 @@ const (
 
 	logFileWasChanged LogFileOperationType = iota
 	posFileWasChanged LogFileOperationType = iota
+
+	// pendingOffsetsLimit bounds, per file, how many not-yet-ingested offsets
+	// we remember while waiting for a matching position-file update. Without a
+	// cap a fluentd that stops reading a file would grow this map forever.
+	pendingOffsetsLimit = 1000
 )
 
 type LogFileOperationType int
 
 type LogFileOperation struct {
-	FileName      string
-	FileSize      int64
+	FileName string
+	FileSize int64
+	// Inode and Device identify the underlying file, independently of
+	// FileName, so that log rotation (kubelet/docker renaming the file and
+	// recreating the original path) can be told apart from data loss.
+	// Device is always 0 for operations parsed out of a position file, since
+	// fluentd's in_tail pos format doesn't record it.
+	Inode         uint64
+	Device        uint64
 	OperationType LogFileOperationType
 }
 
+// pendingOffset is a byte offset observed in a .log file that has not yet
+// been matched to an ingested offset from the corresponding .pos file.
+type pendingOffset struct {
+	offset    int64
+	firstSeen time.Time
+}
+
+// fileIdentity is the (inode, device) pair that identifies the file
+// currently backing a path, so that rotation can be detected even though
+// the path itself doesn't change, and so that bookkeeping that spans a
+// rotation (pendingOffsetsByFile) is keyed by the file it actually
+// describes rather than by a path that can be handed off between files.
+type fileIdentity struct {
+	inode  uint64
+	device uint64
+}
+
+// pendingOffsetsByFile holds, per file identity, the ordered (by increasing
+// offset) list of offsets awaiting ingestion. It is guarded by
+// pendingOffsetsMu since it's written from processOperations only, but reads
+// may be added around it in the future.
+var (
+	pendingOffsetsMu     sync.Mutex
+	pendingOffsetsByFile = map[fileIdentity][]pendingOffset{}
+)
+
+// fileIdentities maps a path to the identity of the file currently backing
+// it, as last observed via a logFileWasChanged operation.
+var (
+	fileIdentitiesMu sync.Mutex
+	fileIdentities   = map[string]fileIdentity{}
+)
+
 func init() {
 	prometheus.MustRegister(logFileActualBytes)
 	prometheus.MustRegister(logFileIngestedBytes)
+	prometheus.MustRegister(logFileIngestionLag)
+	prometheus.MustRegister(logFileIngestionLagEvicted)
+	prometheus.MustRegister(logFileRotatedBytes)
 }
 
-// TODO: GC metrics
-
 func main() {
+	parser := selectPositionParser(*positionFormat, positionFilesLocation)
+
+	if *enableK8sEnrichment {
+		go runK8sEnrichment(*kubeletPodsURL, *kubeletSyncInterval, *enrichmentGCGrace,
+			[]*prometheus.GaugeVec{logFileActualBytes, logFileIngestedBytes, logFileLostBytes})
+	}
+
 	operations := make(chan LogFileOperation, *channelSize)
-	go readLogFiles(*probeInterval, operations, logFilesLocations)
-	go readIngestedSizes(*probeInterval, operations, positionFilesLocation)
+	go watchLogFiles(*probeInterval, operations, logFilesLocations)
+	go watchIngestedSizes(*probeInterval, operations, positionFilesLocation, parser)
 	go processOperations(operations)
 
-	http.Handle("/metrics", prometheus.Handler())
+	http.Handle("/metrics", promhttp.Handler())
 	if err := http.ListenAndServe(fmt.Sprintf(":%d", *port), nil); err != nil {
 		glog.Fatal(err)
 	}
 }
 
-func readLogFiles(probeInterval time.Duration, operations chan LogFileOperation, baseLocations []string) {
-	for range time.Tick(probeInterval) {
-		probeFiles(baseLocations, func(absPath string) {
-			if filepath.Ext(absPath) != logFileExtension {
-				return
+func processOperations(operations chan LogFileOperation) {
+	for operation := range operations {
+		labels := logNameLabels(operation.FileName)
+
+		prev_actual_size, has_prev_actual_size := extractLastGaugeValue(logFileActualBytes, labels...)
+		prev_ingested_size, has_prev_ingested_size := extractLastGaugeValue(logFileIngestedBytes, labels...)
+
+		if operation.OperationType == posFileWasChanged {
+			logFileIngestedBytes.WithLabelValues(labels...).Set(float64(operation.FileSize))
+			if identity, ok := currentFileIdentity(operation.FileName); ok {
+				observeIngestionLag(identity, operation.FileName, operation.FileSize)
 			}
+		}
 
-			stat, err := os.Stat(absPath)
-			if err != nil {
-				glog.Warningf("Failed to stat file %s", absPath)
-				return
+		if operation.OperationType == logFileWasChanged {
+			oldIdentity, identity, rotated := updateFileIdentity(operation.FileName, operation.Inode, operation.Device)
+
+			logFileActualBytes.WithLabelValues(labels...).Set(float64(operation.FileSize))
+
+			if rotated {
+				if has_prev_actual_size && has_prev_ingested_size {
+					outstanding := math.Max(0.0, prev_actual_size-prev_ingested_size)
+					logFileRotatedBytes.WithLabelValues(operation.FileName).Add(outstanding)
+				}
+				resetPendingOffsets(oldIdentity)
+			} else if has_prev_actual_size && has_prev_ingested_size && prev_actual_size < float64(operation.FileSize) {
+				delta := math.Max(0.0, float64(prev_actual_size-prev_ingested_size))
+				logFileLostBytes.WithLabelValues(labels...).Add(delta)
 			}
 
-			operations <- LogFileOperation{
-				FileName:      absPath,
-				FileSize:      stat.Size(),
-				OperationType: logFileWasChanged,
+			if rotated || !has_prev_actual_size || prev_actual_size < float64(operation.FileSize) {
+				recordPendingOffset(identity, operation.FileName, operation.FileSize)
 			}
-		})
+		}
 	}
 }
 
-func readIngestedSizes(probeInterval time.Duration, operations chan LogFileOperation, baseLocation string) {
-	for range time.Tick(probeInterval) {
-		probeFiles([]string{baseLocation}, func(absPath string) {
-			if filepath.Ext(absPath) != posFileExtension {
-				return
-			}
+// currentFileIdentity returns the most recently observed (inode, device) of
+// fileName, as seen via a logFileWasChanged operation, or false if none has
+// been observed yet.
+func currentFileIdentity(fileName string) (fileIdentity, bool) {
+	fileIdentitiesMu.Lock()
+	defer fileIdentitiesMu.Unlock()
 
-			contents, err := ioutil.ReadFile(absPath)
-			if err != nil {
-				glog.Warningf("Failed to read file %s", absPath)
-				return
-			}
-			lines := strings.Split(string(contents), "\n")
-
-			for _, posLine := range lines {
-				if fileName, fileSize, ok := tryParsePosLine(posLine); ok {
-					operations <- LogFileOperation{
-						FileName:      fileName,
-						FileSize:      fileSize,
-						OperationType: posFileWasChanged,
-					}
-				}
-			}
-		})
+	identity, ok := fileIdentities[fileName]
+	return identity, ok
+}
+
+// updateFileIdentity records that fileName is now backed by the file with
+// the given inode/device, returning the identity it was backed by before
+// (the zero value if none) and whether this amounts to a rotation: the
+// inode/device pair changed since the last observation, meaning
+// kubelet/docker renamed the file (e.g. to *.log.1) and recreated the
+// original path between this and the previous logFileWasChanged operation
+// for it. A path seen for the first time is never considered rotated.
+func updateFileIdentity(fileName string, inode, device uint64) (oldIdentity, newIdentity fileIdentity, rotated bool) {
+	newIdentity = fileIdentity{inode: inode, device: device}
+
+	fileIdentitiesMu.Lock()
+	defer fileIdentitiesMu.Unlock()
+
+	var alreadySeen bool
+	oldIdentity, alreadySeen = fileIdentities[fileName]
+	fileIdentities[fileName] = newIdentity
+
+	rotated = alreadySeen && oldIdentity != newIdentity
+	return
+}
+
+// resetPendingOffsets discards any offsets still awaiting ingestion for
+// identity, since after a rotation they belong to the now rotated-away file
+// and can never be matched against the new one's position-file entries.
+func resetPendingOffsets(identity fileIdentity) {
+	pendingOffsetsMu.Lock()
+	defer pendingOffsetsMu.Unlock()
+
+	delete(pendingOffsetsByFile, identity)
+}
+
+// recordPendingOffset remembers that the file identified by identity (whose
+// current path is fileName, used only to label logFileIngestionLagEvicted)
+// grew to offset at the current time, so that once an ingester reports it
+// has consumed up to (at least) that offset we can compute how long it
+// took.
+func recordPendingOffset(identity fileIdentity, fileName string, offset int64) {
+	pendingOffsetsMu.Lock()
+	defer pendingOffsetsMu.Unlock()
+
+	pending := pendingOffsetsByFile[identity]
+	pending = append(pending, pendingOffset{offset: offset, firstSeen: time.Now()})
+
+	if overflow := len(pending) - pendingOffsetsLimit; overflow > 0 {
+		logFileIngestionLagEvicted.WithLabelValues(fileName).Add(float64(overflow))
+		pending = pending[overflow:]
 	}
+
+	pendingOffsetsByFile[identity] = pending
 }
 
-func processOperations(operations chan LogFileOperation) {
-	for operation := range operations {
-		prev_actual_size, has_prev_actual_size := extractLastGaugeValue(logFileActualBytes, operation.FileName)
-		prev_ingested_size, has_prev_ingested_size := extractLastGaugeValue(logFileIngestedBytes, operation.FileName)
+// observeIngestionLag pops every pending offset of identity (whose current
+// path is fileName, used only to label logFileIngestionLag) at or below
+// ingested and records how long it took for that offset to be ingested.
+func observeIngestionLag(identity fileIdentity, fileName string, ingested int64) {
+	pendingOffsetsMu.Lock()
+	defer pendingOffsetsMu.Unlock()
 
-		if operation.OperationType == posFileWasChanged {
-			logFileIngestedBytes.WithLabelValues(operation.FileName).Set(float64(operation.FileSize))
-		}
+	pending := pendingOffsetsByFile[identity]
 
-		if operation.OperationType == logFileWasChanged {
-			logFileActualBytes.WithLabelValues(operation.FileName).Set(float64(operation.FileSize))
+	now := time.Now()
+	i := 0
+	for ; i < len(pending) && pending[i].offset <= ingested; i++ {
+		logFileIngestionLag.WithLabelValues(fileName).Observe(now.Sub(pending[i].firstSeen).Seconds())
+	}
 
-			if has_prev_actual_size && has_prev_ingested_size && prev_actual_size < float64(operation.FileSize) {
-				delta := math.Max(0.0, float64(prev_actual_size-prev_ingested_size))
-				logFileLostBytes.WithLabelValues(operation.FileName).Add(delta)
-			}
-		}
+	if i > 0 {
+		pendingOffsetsByFile[identity] = pending[i:]
 	}
 }
+
 func extractLastGaugeValue(gaugeVec *prometheus.GaugeVec, fields ...string) (float64, bool) {
 	var metric_pb dto.Metric
 	if err := gaugeVec.WithLabelValues(fields...).Write(&metric_pb); err != nil {
@@ -194,17 +334,32 @@ func probeFiles(locations []string, callback func(string)) {
 	}
 }
 
-func tryParsePosLine(posLine string) (string, int64, bool) {
+// rotatedOffsetMarker is the all-zero offset fluentd's in_tail writes for an
+// entry whose underlying file was rotated away; there's nothing meaningful
+// to report until in_tail picks the new inode back up.
+const rotatedOffsetMarker = "0000000000000000"
+
+func tryParsePosLine(posLine string) (fileName string, fileSize int64, inode uint64, ok bool) {
 	chunks := strings.Split(posLine, "\t")
 	if len(chunks) < 3 {
-		return "", 0, false
+		return "", 0, 0, false
+	}
+
+	if chunks[1] == rotatedOffsetMarker {
+		return "", 0, 0, false
 	}
 
-	fileName := chunks[0]
+	fileName = chunks[0]
+
 	fileSize, err := strconv.ParseInt(chunks[1], 16, 64)
 	if err != nil {
-		return "", 0, false
+		return "", 0, 0, false
+	}
+
+	inode, err = strconv.ParseUint(chunks[2], 16, 64)
+	if err != nil {
+		return "", 0, 0, false
 	}
 
-	return fileName, fileSize, true
+	return fileName, fileSize, inode, true
 }
@@ -0,0 +1,69 @@
+/*
+Copyright 2015 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import "testing"
+
+func TestRecordAndObserveIngestionLag(t *testing.T) {
+	identity := fileIdentity{inode: 1, device: 1}
+	resetPendingOffsets(identity)
+
+	recordPendingOffset(identity, "/var/log/containers/a.log", 10)
+	recordPendingOffset(identity, "/var/log/containers/a.log", 20)
+	recordPendingOffset(identity, "/var/log/containers/a.log", 30)
+
+	if got := len(pendingOffsetsByFile[identity]); got != 3 {
+		t.Fatalf("len(pendingOffsetsByFile[identity]) = %d, want 3", got)
+	}
+
+	// An ingested offset of 20 should pop the 10 and 20 entries, leaving 30
+	// still pending.
+	observeIngestionLag(identity, "/var/log/containers/a.log", 20)
+
+	pending := pendingOffsetsByFile[identity]
+	if len(pending) != 1 || pending[0].offset != 30 {
+		t.Fatalf("pendingOffsetsByFile[identity] = %+v, want a single entry with offset 30", pending)
+	}
+}
+
+func TestRecordPendingOffsetEvictsBeyondLimit(t *testing.T) {
+	identity := fileIdentity{inode: 2, device: 1}
+	resetPendingOffsets(identity)
+
+	for i := 0; i < pendingOffsetsLimit+5; i++ {
+		recordPendingOffset(identity, "/var/log/containers/b.log", int64(i))
+	}
+
+	pending := pendingOffsetsByFile[identity]
+	if len(pending) != pendingOffsetsLimit {
+		t.Fatalf("len(pendingOffsetsByFile[identity]) = %d, want %d", len(pending), pendingOffsetsLimit)
+	}
+	if pending[0].offset != 5 {
+		t.Fatalf("oldest surviving offset = %d, want 5 (the first 5 should have been evicted)", pending[0].offset)
+	}
+}
+
+func TestResetPendingOffsetsClearsIdentity(t *testing.T) {
+	identity := fileIdentity{inode: 3, device: 1}
+	recordPendingOffset(identity, "/var/log/containers/c.log", 1)
+
+	resetPendingOffsets(identity)
+
+	if pending, ok := pendingOffsetsByFile[identity]; ok && len(pending) != 0 {
+		t.Fatalf("pendingOffsetsByFile[identity] = %+v, want it cleared", pending)
+	}
+}
@@ -0,0 +1,223 @@
+/*
+Copyright 2015 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	enableK8sEnrichment = flag.Bool("enable-k8s-enrichment", false, "Add pod, namespace, container, container_id and node labels to log_file_actual_bytes, log_file_ingested_bytes and log_file_lost_bytes, parsed from /var/log/containers file names and reconciled against the local kubelet's /pods endpoint")
+	// Default to the kubelet's read-only port: the secure port (10250)
+	// requires a bearer token and a kubelet serving cert that won't
+	// validate against the system pool, neither of which this addon
+	// otherwise needs to set up.
+	kubeletPodsURL      = flag.String("kubelet-pods-url", "http://localhost:10255/pods", "URL of the local kubelet's /pods endpoint, used to resolve the node label and to garbage-collect metrics of deleted pods")
+	kubeletSyncInterval = flag.Duration("kubelet-sync-interval", 10*time.Second, "How often to poll the kubelet's /pods endpoint for enrichment and metrics GC")
+	enrichmentGCGrace   = flag.Duration("enrichment-gc-grace-period", 2*time.Minute, "How long to keep exporting metrics for a log file whose pod is no longer reported by the kubelet, before deleting its series")
+)
+
+// containerLogName is the (pod, namespace, container, containerID) tuple
+// encoded in the name of a /var/log/containers/*.log symlink, e.g.
+// "nginx-6f5dc_default_nginx-3b9c4a1e....log".
+type containerLogName struct {
+	pod, namespace, container, containerID string
+}
+
+var containerLogNamePattern = regexp.MustCompile(`^(.+)_(.+)_(.+)-([0-9a-f]+)\.log$`)
+
+// parseContainerLogName parses baseName, the base name of a file under
+// /var/log/containers, into its (pod, namespace, container, containerID)
+// parts. It returns false for names that don't follow the kubelet's
+// container log naming convention.
+func parseContainerLogName(baseName string) (containerLogName, bool) {
+	groups := containerLogNamePattern.FindStringSubmatch(baseName)
+	if groups == nil {
+		return containerLogName{}, false
+	}
+	return containerLogName{pod: groups[1], namespace: groups[2], container: groups[3], containerID: groups[4]}, true
+}
+
+// kubeletPod is the subset of a corev1.Pod that enrichment cares about, as
+// reported by the kubelet's own /pods endpoint.
+type kubeletPod struct {
+	Metadata struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+	} `json:"metadata"`
+	Spec struct {
+		NodeName string `json:"nodeName"`
+	} `json:"spec"`
+}
+
+type kubeletPodList struct {
+	Items []kubeletPod `json:"items"`
+}
+
+// podEnrichment is what enrichment remembers about a pod: the node it's
+// running on (known only once the kubelet has reported it at least once),
+// and the last time it was seen — either in a kubelet sync, or via
+// logNameLabels tailing one of its log files. Tracking the latter ensures a
+// pod whose log file already existed, but whose pod was already gone, before
+// the exporter's first kubelet sync still gets a grace period before GC,
+// instead of never being tracked at all.
+type podEnrichment struct {
+	node     string
+	lastSeen time.Time
+}
+
+// metricLabelValues is the full set of label values last used for a log
+// file's series across logFileActualBytes, logFileIngestedBytes and
+// logFileLostBytes, remembered so gcPodMetrics can delete exactly those
+// series once the owning pod is gone.
+type metricLabelValues struct {
+	podKey string // "namespace/pod"
+	values []string
+}
+
+var (
+	enrichmentMu sync.Mutex
+	// enrichedPods is keyed by "namespace/pod", as reported by the kubelet.
+	enrichedPods = map[string]podEnrichment{}
+
+	activeLogFilesMu sync.Mutex
+	activeLogFiles   = map[string]metricLabelValues{}
+)
+
+// logNameLabels returns the ordered label values (log_name, pod, namespace,
+// container, container_id, node) for fileName, for use with
+// logFileActualBytes/logFileIngestedBytes/logFileLostBytes. When enrichment
+// is disabled, or fileName doesn't match the kubelet's container log naming
+// convention, every label but log_name is empty.
+func logNameLabels(fileName string) []string {
+	if !*enableK8sEnrichment {
+		return []string{fileName, "", "", "", "", ""}
+	}
+
+	parsed, ok := parseContainerLogName(filepath.Base(fileName))
+	if !ok {
+		return []string{fileName, "", "", "", "", ""}
+	}
+	podKey := parsed.namespace + "/" + parsed.pod
+
+	enrichmentMu.Lock()
+	entry := enrichedPods[podKey]
+	entry.lastSeen = time.Now()
+	enrichedPods[podKey] = entry
+	node := entry.node
+	enrichmentMu.Unlock()
+
+	labels := []string{fileName, parsed.pod, parsed.namespace, parsed.container, parsed.containerID, node}
+
+	activeLogFilesMu.Lock()
+	activeLogFiles[fileName] = metricLabelValues{podKey: podKey, values: labels}
+	activeLogFilesMu.Unlock()
+
+	return labels
+}
+
+// runK8sEnrichment polls the kubelet's /pods endpoint every syncInterval to
+// learn the node label for currently running pods and, once a pod has been
+// missing from the kubelet's response, and none of its log files has been
+// passed to logNameLabels, for gcGrace, deletes the series
+// gaugeVecs hold for that pod's log files. This also covers pods that the
+// kubelet never reported at all (e.g. a log file for a pod that was already
+// deleted before the first sync), since logNameLabels seeds enrichedPods
+// with a lastSeen the first time it parses one of their log files.
+func runK8sEnrichment(podsURL string, syncInterval, gcGrace time.Duration, gaugeVecs []*prometheus.GaugeVec) {
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	for range time.Tick(syncInterval) {
+		pods, err := fetchKubeletPods(client, podsURL)
+		if err != nil {
+			glog.Warningf("Failed to list pods from kubelet at %s: %v", podsURL, err)
+			continue
+		}
+
+		now := time.Now()
+		seen := make(map[string]bool, len(pods.Items))
+
+		enrichmentMu.Lock()
+		for _, pod := range pods.Items {
+			key := pod.Metadata.Namespace + "/" + pod.Metadata.Name
+			enrichedPods[key] = podEnrichment{node: pod.Spec.NodeName, lastSeen: now}
+			seen[key] = true
+		}
+
+		var gone []string
+		for key, enrichment := range enrichedPods {
+			if seen[key] || now.Sub(enrichment.lastSeen) < gcGrace {
+				continue
+			}
+			gone = append(gone, key)
+		}
+		for _, key := range gone {
+			delete(enrichedPods, key)
+		}
+		enrichmentMu.Unlock()
+
+		for _, key := range gone {
+			gcPodMetrics(key, gaugeVecs)
+		}
+	}
+}
+
+// gcPodMetrics deletes the logFileActualBytes/logFileIngestedBytes/
+// logFileLostBytes series of every log file belonging to podKey
+// ("namespace/pod").
+func gcPodMetrics(podKey string, gaugeVecs []*prometheus.GaugeVec) {
+	activeLogFilesMu.Lock()
+	defer activeLogFilesMu.Unlock()
+
+	for fileName, entry := range activeLogFiles {
+		if entry.podKey != podKey {
+			continue
+		}
+		for _, gaugeVec := range gaugeVecs {
+			gaugeVec.DeleteLabelValues(entry.values...)
+		}
+		delete(activeLogFiles, fileName)
+	}
+}
+
+func fetchKubeletPods(client *http.Client, url string) (*kubeletPodList, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("kubelet returned status %s", resp.Status)
+	}
+
+	var pods kubeletPodList
+	if err := json.NewDecoder(resp.Body).Decode(&pods); err != nil {
+		return nil, err
+	}
+	return &pods, nil
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2015 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import "testing"
+
+func TestParseContainerLogName(t *testing.T) {
+	tests := []struct {
+		name     string
+		baseName string
+		want     containerLogName
+		wantOk   bool
+	}{
+		{
+			name:     "well formed container log name",
+			baseName: "nginx-6f5dc_default_nginx-3b9c4a1e.log",
+			want:     containerLogName{pod: "nginx-6f5dc", namespace: "default", container: "nginx", containerID: "3b9c4a1e"},
+			wantOk:   true,
+		},
+		{
+			name:     "missing containerID",
+			baseName: "nginx-6f5dc_default_nginx.log",
+			wantOk:   false,
+		},
+		{
+			name:     "not a log file",
+			baseName: "nginx-6f5dc_default_nginx-3b9c4a1e.txt",
+			wantOk:   false,
+		},
+		{
+			name:     "uppercase containerID is not a container ID",
+			baseName: "nginx-6f5dc_default_nginx-3B9C4A1E.log",
+			wantOk:   false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := parseContainerLogName(test.baseName)
+			if ok != test.wantOk {
+				t.Fatalf("ok = %v, want %v", ok, test.wantOk)
+			}
+			if ok && got != test.want {
+				t.Fatalf("parseContainerLogName(%q) = %+v, want %+v", test.baseName, got, test.want)
+			}
+		})
+	}
+}
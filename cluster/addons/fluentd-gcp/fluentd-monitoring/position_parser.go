@@ -0,0 +1,185 @@
+/*
+Copyright 2015 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang/glog"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// PositionEntry is a single (path, offset) record read out of a position
+// file, regardless of which log forwarder wrote it.
+type PositionEntry struct {
+	FileName string
+	FileSize int64
+	// Inode is the inode of FileName as last known to the forwarder, or 0 if
+	// the position format doesn't record one.
+	Inode uint64
+}
+
+// PositionParser knows how to read the position files written by one log
+// forwarder's tailer and turn them into PositionEntry records.
+type PositionParser interface {
+	// Extension is the file extension position files of this format use,
+	// e.g. ".pos" for fluentd's in_tail. It's used both to filter watcher
+	// events down to files this parser cares about, and by detectPositionFormat
+	// to auto-detect which parser to use.
+	Extension() string
+
+	// Parse reads absPath, a position file matching Extension, and returns
+	// every entry it currently records.
+	Parse(absPath string) ([]PositionEntry, error)
+}
+
+// selectPositionParser returns the PositionParser for format, which is one
+// of "fluentd", "fluent-bit", "cri", or "auto" to detect the format by
+// scanning baseLocation for a recognizable position file.
+func selectPositionParser(format, baseLocation string) PositionParser {
+	switch format {
+	case "fluentd":
+		return fluentdPositionParser{}
+	case "fluent-bit":
+		return fluentBitPositionParser{}
+	case "cri":
+		return criPositionParser{}
+	case "auto":
+		return detectPositionFormat(baseLocation)
+	default:
+		glog.Fatalf("Unknown --position-format %q", format)
+		return nil
+	}
+}
+
+// detectPositionFormat scans baseLocation for a position file matching one
+// of the known formats and returns the corresponding parser, defaulting to
+// fluentdPositionParser if none is found.
+func detectPositionFormat(baseLocation string) PositionParser {
+	candidates := []PositionParser{
+		fluentBitPositionParser{},
+		criPositionParser{},
+		fluentdPositionParser{},
+	}
+
+	entries, err := ioutil.ReadDir(baseLocation)
+	if err != nil {
+		glog.Warningf("Failed to list %s to auto-detect position format, defaulting to fluentd: %v", baseLocation, err)
+		return fluentdPositionParser{}
+	}
+
+	for _, entry := range entries {
+		for _, parser := range candidates {
+			if filepath.Ext(entry.Name()) == parser.Extension() {
+				return parser
+			}
+		}
+	}
+
+	return fluentdPositionParser{}
+}
+
+// fluentdPositionParser reads fluentd in_tail's tab-separated pos files:
+// one line per tailed file, `path\thexOffset\thexInode`.
+type fluentdPositionParser struct{}
+
+func (fluentdPositionParser) Extension() string {
+	return posFileExtension
+}
+
+func (fluentdPositionParser) Parse(absPath string) ([]PositionEntry, error) {
+	contents, err := ioutil.ReadFile(absPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []PositionEntry
+	for _, posLine := range strings.Split(string(contents), "\n") {
+		if fileName, fileSize, inode, ok := tryParsePosLine(posLine); ok {
+			entries = append(entries, PositionEntry{FileName: fileName, FileSize: fileSize, Inode: inode})
+		}
+	}
+
+	return entries, nil
+}
+
+// fluentBitPositionParser reads fluent-bit's SQLite tail-db: a single
+// `in_tail_files` table with one row per tailed file.
+type fluentBitPositionParser struct{}
+
+func (fluentBitPositionParser) Extension() string {
+	return ".db"
+}
+
+func (fluentBitPositionParser) Parse(absPath string) ([]PositionEntry, error) {
+	db, err := sql.Open("sqlite3", "file:"+absPath+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT name, offset, inode FROM in_tail_files")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []PositionEntry
+	for rows.Next() {
+		var entry PositionEntry
+		if err := rows.Scan(&entry.FileName, &entry.FileSize, &entry.Inode); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// criPositionParser reads the checkpoint JSON files CRI-compatible log
+// tailers (e.g. containerd's cri plugin) write next to the log files they
+// track, one checkpoint file per tailed log.
+type criPositionParser struct{}
+
+func (criPositionParser) Extension() string {
+	return ".checkpoint"
+}
+
+// criCheckpoint mirrors the fields of a single CRI log tailer checkpoint
+// file that this exporter cares about.
+type criCheckpoint struct {
+	LogPath string `json:"logPath"`
+	Offset  int64  `json:"offset"`
+}
+
+func (criPositionParser) Parse(absPath string) ([]PositionEntry, error) {
+	contents, err := ioutil.ReadFile(absPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var checkpoint criCheckpoint
+	if err := json.Unmarshal(contents, &checkpoint); err != nil {
+		return nil, err
+	}
+
+	return []PositionEntry{{FileName: checkpoint.LogPath, FileSize: checkpoint.Offset}}, nil
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2015 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestTryParsePosLine(t *testing.T) {
+	tests := []struct {
+		name         string
+		line         string
+		wantFileName string
+		wantFileSize int64
+		wantInode    uint64
+		wantOk       bool
+	}{
+		{
+			name:         "well formed line",
+			line:         "/var/log/containers/a.log\t1a\t2b",
+			wantFileName: "/var/log/containers/a.log",
+			wantFileSize: 0x1a,
+			wantInode:    0x2b,
+			wantOk:       true,
+		},
+		{
+			name:   "rotated offset marker",
+			line:   "/var/log/containers/a.log\t0000000000000000\t2b",
+			wantOk: false,
+		},
+		{
+			name:   "too few columns",
+			line:   "/var/log/containers/a.log\t1a",
+			wantOk: false,
+		},
+		{
+			name:   "non-hex size",
+			line:   "/var/log/containers/a.log\tzz\t2b",
+			wantOk: false,
+		},
+		{
+			name:   "empty line",
+			line:   "",
+			wantOk: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fileName, fileSize, inode, ok := tryParsePosLine(test.line)
+			if ok != test.wantOk {
+				t.Fatalf("ok = %v, want %v", ok, test.wantOk)
+			}
+			if !ok {
+				return
+			}
+			if fileName != test.wantFileName || fileSize != test.wantFileSize || inode != test.wantInode {
+				t.Fatalf("got (%q, %d, %d), want (%q, %d, %d)", fileName, fileSize, inode, test.wantFileName, test.wantFileSize, test.wantInode)
+			}
+		})
+	}
+}
+
+func TestFluentdPositionParser(t *testing.T) {
+	dir := t.TempDir()
+	posFile := filepath.Join(dir, "containers.pos")
+	contents := "/var/log/containers/a.log\t1a\t2b\n/var/log/containers/b.log\t0000000000000000\t3c\n"
+	if err := ioutil.WriteFile(posFile, []byte(contents), 0644); err != nil {
+		t.Fatalf("Failed to write pos file: %v", err)
+	}
+
+	entries, err := (fluentdPositionParser{}).Parse(posFile)
+	if err != nil {
+		t.Fatalf("Parse() returned error: %v", err)
+	}
+
+	if len(entries) != 1 {
+		t.Fatalf("len(entries) = %d, want 1 (rotated-away entry should be skipped)", len(entries))
+	}
+	if entries[0].FileName != "/var/log/containers/a.log" || entries[0].FileSize != 0x1a || entries[0].Inode != 0x2b {
+		t.Fatalf("entries[0] = %+v, want {/var/log/containers/a.log 26 43}", entries[0])
+	}
+}
+
+func TestCriPositionParser(t *testing.T) {
+	dir := t.TempDir()
+	checkpointFile := filepath.Join(dir, "a.log.checkpoint")
+	contents := `{"logPath":"/var/log/containers/a.log","offset":123}`
+	if err := ioutil.WriteFile(checkpointFile, []byte(contents), 0644); err != nil {
+		t.Fatalf("Failed to write checkpoint file: %v", err)
+	}
+
+	entries, err := (criPositionParser{}).Parse(checkpointFile)
+	if err != nil {
+		t.Fatalf("Parse() returned error: %v", err)
+	}
+
+	if len(entries) != 1 || entries[0].FileName != "/var/log/containers/a.log" || entries[0].FileSize != 123 {
+		t.Fatalf("entries = %+v, want a single {/var/log/containers/a.log 123 0} entry", entries)
+	}
+}
+
+func TestFluentBitPositionParser(t *testing.T) {
+	dir := t.TempDir()
+	dbFile := filepath.Join(dir, "tail.db")
+
+	db, err := sql.Open("sqlite3", dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create tail-db: %v", err)
+	}
+	if _, err := db.Exec(`CREATE TABLE in_tail_files (name TEXT, offset INTEGER, inode INTEGER)`); err != nil {
+		t.Fatalf("Failed to create in_tail_files: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO in_tail_files (name, offset, inode) VALUES (?, ?, ?)`, "/var/log/containers/a.log", 42, 7); err != nil {
+		t.Fatalf("Failed to insert row: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Failed to close tail-db: %v", err)
+	}
+
+	entries, err := (fluentBitPositionParser{}).Parse(dbFile)
+	if err != nil {
+		t.Fatalf("Parse() returned error: %v", err)
+	}
+
+	if len(entries) != 1 || entries[0].FileName != "/var/log/containers/a.log" || entries[0].FileSize != 42 || entries[0].Inode != 7 {
+		t.Fatalf("entries = %+v, want a single {/var/log/containers/a.log 42 7} entry", entries)
+	}
+}
+
+func TestDetectPositionFormat(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.checkpoint"), []byte("{}"), 0644); err != nil {
+		t.Fatalf("Failed to write checkpoint file: %v", err)
+	}
+
+	parser := detectPositionFormat(dir)
+	if _, ok := parser.(criPositionParser); !ok {
+		t.Fatalf("detectPositionFormat() = %T, want criPositionParser", parser)
+	}
+}
+
+func TestDetectPositionFormatDefaultsToFluentd(t *testing.T) {
+	dir := t.TempDir()
+
+	parser := detectPositionFormat(dir)
+	if _, ok := parser.(fluentdPositionParser); !ok {
+		t.Fatalf("detectPositionFormat() on an empty dir = %T, want fluentdPositionParser", parser)
+	}
+}
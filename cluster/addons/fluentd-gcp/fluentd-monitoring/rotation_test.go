@@ -0,0 +1,80 @@
+/*
+Copyright 2015 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import "testing"
+
+func TestUpdateFileIdentityFirstObservationIsNotRotation(t *testing.T) {
+	fileName := "/var/log/containers/rotation-first.log"
+
+	_, identity, rotated := updateFileIdentity(fileName, 42, 7)
+	if rotated {
+		t.Fatalf("first observation of %s reported as rotated", fileName)
+	}
+	if identity != (fileIdentity{inode: 42, device: 7}) {
+		t.Fatalf("identity = %+v, want {42 7}", identity)
+	}
+}
+
+func TestUpdateFileIdentityDetectsRotation(t *testing.T) {
+	fileName := "/var/log/containers/rotation-changed.log"
+
+	updateFileIdentity(fileName, 1, 1)
+
+	oldIdentity, newIdentity, rotated := updateFileIdentity(fileName, 2, 1)
+	if !rotated {
+		t.Fatalf("inode change for %s not detected as rotation", fileName)
+	}
+	if oldIdentity != (fileIdentity{inode: 1, device: 1}) {
+		t.Fatalf("oldIdentity = %+v, want {1 1}", oldIdentity)
+	}
+	if newIdentity != (fileIdentity{inode: 2, device: 1}) {
+		t.Fatalf("newIdentity = %+v, want {2 1}", newIdentity)
+	}
+}
+
+func TestUpdateFileIdentitySameIdentityIsNotRotation(t *testing.T) {
+	fileName := "/var/log/containers/rotation-stable.log"
+
+	updateFileIdentity(fileName, 5, 9)
+	_, _, rotated := updateFileIdentity(fileName, 5, 9)
+
+	if rotated {
+		t.Fatalf("unchanged (inode, device) for %s reported as rotated", fileName)
+	}
+}
+
+func TestRotationFlushesPendingOffsetsUnderTheOldIdentityOnly(t *testing.T) {
+	fileName := "/var/log/containers/rotation-flush.log"
+
+	oldIdentity, _, _ := updateFileIdentity(fileName, 10, 1)
+	recordPendingOffset(oldIdentity, fileName, 100)
+
+	_, newIdentity, rotated := updateFileIdentity(fileName, 11, 1)
+	if !rotated {
+		t.Fatalf("expected rotation when inode changes for %s", fileName)
+	}
+	resetPendingOffsets(oldIdentity)
+	recordPendingOffset(newIdentity, fileName, 5)
+
+	if pending := pendingOffsetsByFile[oldIdentity]; len(pending) != 0 {
+		t.Fatalf("pendingOffsetsByFile[oldIdentity] = %+v, want it cleared after rotation", pending)
+	}
+	if pending := pendingOffsetsByFile[newIdentity]; len(pending) != 1 || pending[0].offset != 5 {
+		t.Fatalf("pendingOffsetsByFile[newIdentity] = %+v, want a single entry with offset 5", pending)
+	}
+}
@@ -17,12 +17,19 @@ limitations under the License.
 package e2e
 
 import (
+	"bufio"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"net/http"
+	"os"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
+	"golang.org/x/net/context"
+
 	"k8s.io/kubernetes/pkg/api"
 	"k8s.io/kubernetes/pkg/util/intstr"
 	"k8s.io/kubernetes/test/e2e/framework"
@@ -31,15 +38,24 @@ import (
 	. "github.com/onsi/gomega"
 )
 
-var _ = framework.KubeDescribe("Cluster level logging stress-test using GCL", func() {
-	f := framework.NewDefaultFramework("gcl-logging-stress")
+// loggingBackendFlag selects which cluster-logging addon the stress test
+// validates; it defaults to the LOGGING_BACKEND environment variable so CI
+// jobs can choose a backend without threading a new flag through.
+var loggingBackendFlag = flag.String("logging-backend", os.Getenv("LOGGING_BACKEND"), "Which cluster-logging backend to validate: gcl, elasticsearch, or http. Defaults to the LOGGING_BACKEND env var, then gcl")
+
+var _ = framework.KubeDescribe("Cluster level logging stress-test", func() {
+	f := framework.NewDefaultFramework("logging-stress")
 
 	BeforeEach(func() {
 		framework.SkipUnlessProviderIs("gce")
 	})
 
-	It("should check that logs from pods on all nodes are ingested into GCL", func() {
-		ClusterLevelLoggingStressWithGcl(f)
+	It("should check that logs from pods on all nodes are ingested into the cluster-logging backend", func() {
+		backend, err := newLoggingBackend(loggingBackendName(), f)
+		Expect(err).NotTo(HaveOccurred(), "Failed to set up logging backend")
+		defer backend.Cleanup()
+
+		ClusterLevelLoggingStress(f, backend)
 	})
 })
 
@@ -52,20 +68,259 @@ const (
 	logGeneratorContainerName = "log-generator"
 
 	logGeneratorPort = 8080
+
+	// logFormat is the log-generator format query parameter this stress test
+	// sends to /generate, so
+	// ingestion is exercised against structured JSON payloads rather than
+	// just plain text.
+	logFormat = "json"
+
+	elasticsearchServiceName = "elasticsearch-logging"
+	elasticsearchNamespace   = "kube-system"
 )
 
-// ClusterLevelLoggingWithGcl is an end to end test for cluster level logging.
-func ClusterLevelLoggingStressWithGcl(f *framework.Framework) {
-	// Wait for the Fluentd pods to enter the running state.
-	By("Checking to make sure the Fluentd pod are running on each healthy node")
-	// Obtain a list of healthy nodes so we can place one synthetic logger on each node.
-	nodes := getHealthyNodes(f)
-	fluentdPods, err := getFluentdPods(f)
-	Expect(err).NotTo(HaveOccurred(), "Failed to obtain fluentd pods")
-	err = waitForFluentdPods(f, nodes, fluentdPods)
-	Expect(err).NotTo(HaveOccurred(), "Failed to wait for fluentd pods entering running state")
+// loggingBackendName returns the --logging-backend/LOGGING_BACKEND value to
+// use, defaulting to "gcl" for backwards compatibility with the original,
+// GCL-only version of this test.
+func loggingBackendName() string {
+	if *loggingBackendFlag == "" {
+		return "gcl"
+	}
+	return *loggingBackendFlag
+}
+
+// LoggingBackend abstracts over the cluster-logging addon under test, so the
+// stress harness (log generators, missing-line analysis, per-pod reporting)
+// can validate GCL, Elasticsearch, or any HTTP-queryable backend without
+// changing its control flow.
+type LoggingBackend interface {
+	// WaitReady blocks until the backend is ready to serve
+	// ReadEntriesForPod queries, or ctx is done.
+	WaitReady(ctx context.Context) error
+
+	// ReadEntriesForPod returns every log entry the backend has ingested so
+	// far for pod.
+	ReadEntriesForPod(pod string) ([]*LogEntry, error)
+
+	// Cleanup releases anything WaitReady or ReadEntriesForPod set up, e.g.
+	// apiserver proxy connections.
+	Cleanup()
+}
+
+// newLoggingBackend constructs the LoggingBackend named by backendName.
+func newLoggingBackend(backendName string, f *framework.Framework) (LoggingBackend, error) {
+	switch backendName {
+	case "gcl":
+		return &gclLoggingBackend{f: f}, nil
+	case "elasticsearch":
+		return &elasticsearchLoggingBackend{f: f}, nil
+	case "http":
+		return newHTTPLoggingBackend()
+	default:
+		return nil, fmt.Errorf("Unknown --logging-backend %q", backendName)
+	}
+}
+
+// gclLoggingBackend reads entries out of Google Cloud Logging, the original
+// backend this stress test was written against.
+type gclLoggingBackend struct {
+	f *framework.Framework
+}
+
+func (b *gclLoggingBackend) WaitReady(ctx context.Context) error {
+	nodes := getHealthyNodes(b.f)
+	fluentdPods, err := getFluentdPods(b.f)
+	if err != nil {
+		return err
+	}
+	return waitForFluentdPods(b.f, nodes, fluentdPods)
+}
+
+func (b *gclLoggingBackend) ReadEntriesForPod(pod string) ([]*LogEntry, error) {
+	filter := fmt.Sprintf("resource.labels.pod_id=%s", pod)
+	return readFilteredEntriesFromGcl(filter)
+}
+
+func (b *gclLoggingBackend) Cleanup() {}
+
+// elasticsearchLoggingBackend reads entries out of the Elasticsearch service
+// installed by the fluentd-elasticsearch addon, via the apiserver proxy.
+type elasticsearchLoggingBackend struct {
+	f *framework.Framework
+}
+
+func (b *elasticsearchLoggingBackend) WaitReady(ctx context.Context) error {
+	nodes := getHealthyNodes(b.f)
+	fluentdPods, err := getFluentdPods(b.f)
+	if err != nil {
+		return err
+	}
+	if err := waitForFluentdPods(b.f, nodes, fluentdPods); err != nil {
+		return err
+	}
+
+	return pollUntilReady(ctx, 5*time.Second, func() (bool, error) {
+		_, err := b.search("")
+		return err == nil, nil
+	})
+}
+
+// esSearchResponse is the subset of an Elasticsearch _search response this
+// backend cares about.
+type esSearchResponse struct {
+	Hits struct {
+		Hits []struct {
+			Source struct {
+				Log string `json:"log"`
+			} `json:"_source"`
+		} `json:"hits"`
+	} `json:"hits"`
+}
+
+// esMaxResultWindow matches Elasticsearch's default index.max_result_window;
+// asking for more hits than this in a single query fails with "Result
+// window is too large" rather than being silently capped.
+const esMaxResultWindow = 10000
+
+func (b *elasticsearchLoggingBackend) search(pod string) (*esSearchResponse, error) {
+	query := map[string]interface{}{
+		"size": esMaxResultWindow,
+	}
+	if pod != "" {
+		query["query"] = map[string]interface{}{
+			"term": map[string]string{"kubernetes.pod_name": pod},
+		}
+	}
+	body, err := json.Marshal(query)
+	if err != nil {
+		return nil, err
+	}
+
+	proxyRequest, err := framework.GetServicesProxyRequest(b.f.Client, b.f.Client.Get())
+	if err != nil {
+		return nil, err
+	}
+
+	result := proxyRequest.Namespace(elasticsearchNamespace).
+		Name(elasticsearchServiceName).
+		Suffix("_search").
+		Body(body).
+		Do()
+	if result.Error() != nil {
+		return nil, result.Error()
+	}
+
+	raw, err := result.Raw()
+	if err != nil {
+		return nil, err
+	}
+
+	var response esSearchResponse
+	if err := json.Unmarshal(raw, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// ReadEntriesForPod runs a single _search query, so it returns at most
+// esMaxResultWindow entries for pod; hits beyond that are not fetched.
+func (b *elasticsearchLoggingBackend) ReadEntriesForPod(pod string) ([]*LogEntry, error) {
+	response, err := b.search(pod)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*LogEntry, 0, len(response.Hits.Hits))
+	for _, hit := range response.Hits.Hits {
+		entries = append(entries, &LogEntry{TextPayload: hit.Source.Log})
+	}
+	return entries, nil
+}
+
+func (b *elasticsearchLoggingBackend) Cleanup() {}
+
+// httpLoggingBackend reads entries from an arbitrary HTTP endpoint, for
+// cluster-logging addons that expose their own query API rather than GCL or
+// Elasticsearch. --logging-backend-url is expected to contain a single %s
+// placeholder for the pod name and return one log entry per line.
+type httpLoggingBackend struct {
+	client  *http.Client
+	urlTmpl string
+}
+
+var loggingBackendURLFlag = flag.String("logging-backend-url", "", "URL template (with a %s placeholder for the pod name) to query for --logging-backend=http, returning one log line per entry")
+
+func newHTTPLoggingBackend() (*httpLoggingBackend, error) {
+	if *loggingBackendURLFlag == "" {
+		return nil, fmt.Errorf("--logging-backend-url is required for --logging-backend=http")
+	}
+	return &httpLoggingBackend{client: &http.Client{Timeout: 30 * time.Second}, urlTmpl: *loggingBackendURLFlag}, nil
+}
+
+func (b *httpLoggingBackend) WaitReady(ctx context.Context) error {
+	return pollUntilReady(ctx, 5*time.Second, func() (bool, error) {
+		resp, err := b.client.Get(fmt.Sprintf(b.urlTmpl, ""))
+		if err != nil {
+			return false, nil
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusOK, nil
+	})
+}
+
+// pollUntilReady calls condition every interval until it reports ready, an
+// error, or ctx is done.
+func pollUntilReady(ctx context.Context, interval time.Duration, condition func() (ready bool, err error)) error {
+	for {
+		ready, err := condition()
+		if err != nil {
+			return err
+		}
+		if ready {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+func (b *httpLoggingBackend) ReadEntriesForPod(pod string) ([]*LogEntry, error) {
+	resp, err := b.client.Get(fmt.Sprintf(b.urlTmpl, pod))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("backend returned status %s", resp.Status)
+	}
+
+	var entries []*LogEntry
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			entries = append(entries, &LogEntry{TextPayload: line})
+		}
+	}
+	return entries, scanner.Err()
+}
+
+func (b *httpLoggingBackend) Cleanup() {}
+
+// ClusterLevelLoggingStress is an end to end test for cluster level logging,
+// validated against whichever LoggingBackend the caller constructed.
+func ClusterLevelLoggingStress(f *framework.Framework, backend LoggingBackend) {
+	By("Waiting for the logging backend to be ready")
+	waitCtx, cancel := context.WithTimeout(context.Background(), framework.TestContext.SystemPodsStartupTimeout)
+	defer cancel()
+	Expect(backend.WaitReady(waitCtx)).NotTo(HaveOccurred(), "Failed to wait for the logging backend to become ready")
 
 	By("Creating log generators")
+	nodes := getHealthyNodes(f)
 	podNames, serviceNames, err := createLogGenerators(f, nodes)
 	Expect(err).NotTo(HaveOccurred(), "Failed to create log generators")
 
@@ -80,9 +335,9 @@ func ClusterLevelLoggingStressWithGcl(f *framework.Framework) {
 	By("Waiting for log generators to finish")
 	time.Sleep(logGenerationDuration)
 
-	// Make several attempts to observe the logs ingested into GCL
-	By("Checking all the log lines were ingested into GCL")
-	totalMissing, missingPerNode := waitForStressLogsToIngest(podNames)
+	// Make several attempts to observe the logs ingested into the backend.
+	By("Checking all the log lines were ingested")
+	totalMissing, missingPerNode := waitForStressLogsToIngest(backend, podNames)
 
 	for podName, missing := range missingPerNode {
 		if len(missing) == 0 {
@@ -90,7 +345,7 @@ func ClusterLevelLoggingStressWithGcl(f *framework.Framework) {
 		}
 
 		missingString := createMissingString(missing)
-		framework.Logf("Pod %d is missing %d lines of logs: %s", podName, len(missing), missingString)
+		framework.Logf("Pod %s is missing %d lines of logs: %s", podName, len(missing), missingString)
 	}
 
 	if totalMissing != 0 {
@@ -192,6 +447,7 @@ func sendLoggingRequests(f *framework.Framework, serviceNames []string) error {
 			Suffix("generate").
 			Param("lines_total", strconv.Itoa(linesPerPod)).
 			Param("duration", logGenerationDuration.String()).
+			Param("format", logFormat).
 			Do()
 
 		if result.Error() != nil {
@@ -219,7 +475,7 @@ func createMissingString(missingEntries []int) (result string) {
 	return
 }
 
-func waitForStressLogsToIngest(podNames []string) (totalMissing int, missingPerPod map[string][]int) {
+func waitForStressLogsToIngest(backend LoggingBackend, podNames []string) (totalMissing int, missingPerPod map[string][]int) {
 	for _, podName := range podNames {
 		missing := make([]int, linesPerPod)
 		for i := 0; i < linesPerPod; i++ {
@@ -231,16 +487,15 @@ func waitForStressLogsToIngest(podNames []string) (totalMissing int, missingPerP
 	for start := time.Now(); time.Since(start) < ingestionTimeout; time.Sleep(25 * time.Second) {
 		newMissingPerPod := make(map[string][]int)
 		for _, podName := range podNames {
-			filter := fmt.Sprintf("resource.labels.pod_id=%s", podName)
 			newMissingPerPod[podName] = missingPerPod[podName]
 
-			entries, err := readFilteredEntriesFromGcl(filter)
+			entries, err := backend.ReadEntriesForPod(podName)
 			if err != nil {
-				framework.Logf("Failed to read events from gcl after %v due to %v", time.Since(start), err)
+				framework.Logf("Failed to read events from the logging backend after %v due to %v", time.Since(start), err)
 				continue
 			}
 
-			newMissingPerPod[podName] = analyzeEntries(entries)
+			newMissingPerPod[podName] = analyzeEntries(entries, logFormat)
 		}
 
 		missingPerPod := newMissingPerPod
@@ -260,11 +515,11 @@ func waitForStressLogsToIngest(podNames []string) (totalMissing int, missingPerP
 	return
 }
 
-func analyzeEntries(entries []*LogEntry) (missing []int) {
+func analyzeEntries(entries []*LogEntry, format string) (missing []int) {
 	count := make(map[int]int)
 
 	for _, entry := range entries {
-		if id, ok := getIdFromPayload(entry.TextPayload); ok {
+		if id, ok := getIdFromPayload(entry.TextPayload, format); ok {
 			count[id]++
 		}
 	}
@@ -279,16 +534,51 @@ func analyzeEntries(entries []*LogEntry) (missing []int) {
 	return
 }
 
-func getIdFromPayload(payload string) (result int, ok bool) {
-	chunks := strings.Split(payload, " ")
-	if len(chunks) < 2 {
-		return
-	}
+// generatedJSONLogLine mirrors the payload the log-generator emits for
+// format=json; trace_id is checked for non-emptiness as a minimal proof
+// that structured fields survived round-tripping through the ingestion
+// backend, not just the id used to detect missing lines.
+type generatedJSONLogLine struct {
+	ID      int    `json:"id"`
+	TraceID string `json:"trace_id"`
+}
 
-	if num, err := strconv.Atoi(chunks[1]); err != nil {
-		ok = true
-		result = num
-	}
+var (
+	klogIdPattern      = regexp.MustCompile(`\bid=(\d+)\b`)
+	klogTraceIdPattern = regexp.MustCompile(`\btrace_id=\S+\b`)
+)
 
-	return
+// getIdFromPayload parses payload, a single ingested log line, according to
+// format (the log-generator format parameter that produced it) and returns the id
+// embedded in it, along with whether the line's structured fields (where the
+// format has any) round-tripped intact.
+func getIdFromPayload(payload string, format string) (result int, ok bool) {
+	switch format {
+	case "json":
+		var line generatedJSONLogLine
+		if err := json.Unmarshal([]byte(payload), &line); err != nil || line.TraceID == "" {
+			return
+		}
+		return line.ID, true
+	case "klog":
+		idMatch := klogIdPattern.FindStringSubmatch(payload)
+		if idMatch == nil || !klogTraceIdPattern.MatchString(payload) {
+			return
+		}
+		num, err := strconv.Atoi(idMatch[1])
+		if err != nil {
+			return
+		}
+		return num, true
+	default:
+		chunks := strings.Split(payload, " ")
+		if len(chunks) < 2 {
+			return
+		}
+		num, err := strconv.Atoi(chunks[1])
+		if err != nil {
+			return
+		}
+		return num, true
+	}
 }
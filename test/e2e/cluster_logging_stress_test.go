@@ -0,0 +1,93 @@
+/*
+Copyright 2015 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package e2e
+
+import "testing"
+
+func TestGetIdFromPayload(t *testing.T) {
+	tests := []struct {
+		name       string
+		payload    string
+		format     string
+		wantResult int
+		wantOk     bool
+	}{
+		{
+			name:       "plain",
+			payload:    "0 42",
+			format:     "plain",
+			wantResult: 42,
+			wantOk:     true,
+		},
+		{
+			name:    "plain too few fields",
+			payload: "42",
+			format:  "plain",
+			wantOk:  false,
+		},
+		{
+			name:       "json",
+			payload:    `{"id":7,"trace_id":"abc123"}`,
+			format:     "json",
+			wantResult: 7,
+			wantOk:     true,
+		},
+		{
+			name:    "json missing trace_id",
+			payload: `{"id":7,"trace_id":""}`,
+			format:  "json",
+			wantOk:  false,
+		},
+		{
+			name:    "json malformed",
+			payload: `not json`,
+			format:  "json",
+			wantOk:  false,
+		},
+		{
+			name:       "klog",
+			payload:    `I0101 00:00:00.000000       1 cluster_logging_stress.go:1] id=13 trace_id=abc123`,
+			format:     "klog",
+			wantResult: 13,
+			wantOk:     true,
+		},
+		{
+			name:    "klog missing trace_id",
+			payload: `I0101 00:00:00.000000       1 cluster_logging_stress.go:1] id=13`,
+			format:  "klog",
+			wantOk:  false,
+		},
+		{
+			name:    "klog missing id",
+			payload: `I0101 00:00:00.000000       1 cluster_logging_stress.go:1] trace_id=abc123`,
+			format:  "klog",
+			wantOk:  false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, ok := getIdFromPayload(test.payload, test.format)
+			if ok != test.wantOk {
+				t.Fatalf("ok = %v, want %v", ok, test.wantOk)
+			}
+			if ok && result != test.wantResult {
+				t.Fatalf("getIdFromPayload(%q, %q) = %d, want %d", test.payload, test.format, result, test.wantResult)
+			}
+		})
+	}
+}
@@ -17,9 +17,12 @@ limitations under the License.
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"math/rand"
 	"net/http"
+	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
 	"time"
@@ -32,10 +35,22 @@ const (
 
 	linesTotalParam = "lines_total"
 	durationParam   = "duration"
+	formatParam     = "format"
 
 	parametersBadRequestFormat = "Error parsing parameters: %v"
 )
 
+// logFormat selects how generateLogLine renders a line, so the e2e stress
+// test can check that the ingestion pipeline preserves structured payloads
+// and not just plain text.
+type logFormat string
+
+const (
+	plainFormat logFormat = "plain"
+	jsonFormat  logFormat = "json"
+	klogFormat  logFormat = "klog"
+)
+
 var (
 	httpMethods = []string{
 		"GET",
@@ -54,8 +69,28 @@ var (
 		"endpoints",
 		"configmaps",
 	}
+	logLevels = []string{
+		"INFO",
+		"WARN",
+		"ERROR",
+		"DEBUG",
+	}
 )
 
+// jsonLogLine is the payload emitted for each line in jsonFormat. Field
+// names are kept short and lower_snake_case to match what a JSON log parser
+// in the ingestion pipeline would expect.
+type jsonLogLine struct {
+	Timestamp string `json:"ts"`
+	ID        int    `json:"id"`
+	Level     string `json:"level"`
+	Method    string `json:"method"`
+	URL       string `json:"url"`
+	Status    int64  `json:"status"`
+	Message   string `json:"msg"`
+	TraceID   string `json:"trace_id"`
+}
+
 type LogsGeneratorHandler struct{}
 
 func NewLogsGeneratorHandler() *LogsGeneratorHandler {
@@ -64,7 +99,7 @@ func NewLogsGeneratorHandler() *LogsGeneratorHandler {
 
 func (handler *LogsGeneratorHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	if strings.ToLower(req.URL.Path) == generateMethod {
-		linesTotal, durationSeconds, err := parseGenerateParameters(req)
+		linesTotal, durationSeconds, format, err := parseGenerateParameters(req)
 
 		if err != nil {
 			errorMessage := fmt.Sprintf(parametersBadRequestFormat, err)
@@ -72,7 +107,7 @@ func (handler *LogsGeneratorHandler) ServeHTTP(w http.ResponseWriter, req *http.
 			return
 		}
 
-		go handler.generateLogs(linesTotal, durationSeconds)
+		go handler.generateLogs(linesTotal, durationSeconds, format)
 
 		return
 	}
@@ -80,18 +115,18 @@ func (handler *LogsGeneratorHandler) ServeHTTP(w http.ResponseWriter, req *http.
 	http.Error(w, fmt.Sprintf("Unknown method: %s", req.URL.Path), http.StatusNotFound)
 }
 
-func (handler *LogsGeneratorHandler) generateLogs(linesTotal int, durationSeconds int) {
+func (handler *LogsGeneratorHandler) generateLogs(linesTotal int, durationSeconds int, format logFormat) {
 	delay := time.Duration(float64(durationSeconds) / float64(linesTotal) * float64(time.Second))
 	randomSource := random.NewSource()
 
 	for i := 0; i < linesTotal; i++ {
-		fmt.Println(generateLogLine(randomSource, i))
+		fmt.Println(generateLogLine(randomSource, i, format))
 
 		time.Sleep(delay)
 	}
 }
 
-func generateLogLine(randomSource rand.Source, id int) string {
+func generateLogLine(randomSource rand.Source, id int, format logFormat) string {
 	method := httpMethods[int(randomSource.Int63())%len(httpMethods)]
 	namespace := namespaces[int(randomSource.Int63())%len(namespaces)]
 	resource := resources[int(randomSource.Int63())%len(resources)]
@@ -99,7 +134,67 @@ func generateLogLine(randomSource rand.Source, id int) string {
 	url := fmt.Sprintf("/api/v1/namespaces/%s/%s/%s", namespace, resource, resourceName)
 	status := 200 + randomSource.Int63()%300
 
-	return fmt.Sprintf("%s %d %s %s %d", time.Now().Format(time.RFC3339), id, method, url, status)
+	switch format {
+	case jsonFormat:
+		return generateJSONLogLine(randomSource, id, method, url, status)
+	case klogFormat:
+		return generateKlogLogLine(randomSource, id, method, url, status)
+	default:
+		return fmt.Sprintf("%s %d %s %s %d", time.Now().Format(time.RFC3339), id, method, url, status)
+	}
+}
+
+// generateJSONLogLine renders id as a single JSON object per line, so the
+// e2e stress test can check that structured fields survive round-tripping
+// through the ingestion backend.
+func generateJSONLogLine(randomSource rand.Source, id int, method, url string, status int64) string {
+	level := logLevels[int(randomSource.Int63())%len(logLevels)]
+	msg := fmt.Sprintf("%s %s -> %d", method, url, status)
+
+	line := jsonLogLine{
+		Timestamp: time.Now().Format(time.RFC3339),
+		ID:        id,
+		Level:     level,
+		Method:    method,
+		URL:       url,
+		Status:    status,
+		Message:   msg,
+		TraceID:   generateTraceID(randomSource),
+	}
+
+	encoded, err := json.Marshal(line)
+	if err != nil {
+		// jsonLogLine only has JSON-safe field types, so this can't happen.
+		panic(err)
+	}
+
+	return string(encoded)
+}
+
+// generateKlogLogLine renders id matching Kubernetes' klog v2 style:
+// "Ihhmmss.microseconds file:line] key=value ...".
+func generateKlogLogLine(randomSource rand.Source, id int, method, url string, status int64) string {
+	_, file, line, _ := runtime.Caller(0)
+	now := time.Now()
+
+	level := logLevels[int(randomSource.Int63())%len(logLevels)]
+	msg := fmt.Sprintf("%s %s -> %d", method, url, status)
+
+	return fmt.Sprintf("I%02d%02d%02d.%06d %s:%d] id=%d level=%s method=%s url=%s status=%d msg=%q trace_id=%s",
+		now.Hour(), now.Minute(), now.Second(), now.Nanosecond()/1000,
+		filepath.Base(file), line,
+		id, level, method, url, status, msg, generateTraceID(randomSource))
+}
+
+// generateTraceID returns a random 16-byte hex string, mimicking the
+// trace IDs a real request handler would attach to its log lines.
+func generateTraceID(randomSource rand.Source) string {
+	bytes := make([]byte, 16)
+	for i := range bytes {
+		bytes[i] = byte(randomSource.Int63())
+	}
+
+	return fmt.Sprintf("%x", bytes)
 }
 
 func generateRandomName(randomSource rand.Source) string {
@@ -113,7 +208,7 @@ func generateRandomName(randomSource rand.Source) string {
 	return string(runes)
 }
 
-func parseGenerateParameters(req *http.Request) (linesTotal int, durationSeconds int, err error) {
+func parseGenerateParameters(req *http.Request) (linesTotal int, durationSeconds int, format logFormat, err error) {
 	query := req.URL.Query()
 
 	linesTotalStr := query.Get(linesTotalParam)
@@ -143,5 +238,14 @@ func parseGenerateParameters(req *http.Request) (linesTotal int, durationSeconds
 		return
 	}
 
+	format = logFormat(query.Get(formatParam))
+	if format == "" {
+		format = plainFormat
+	}
+	if format != plainFormat && format != jsonFormat && format != klogFormat {
+		err = fmt.Errorf("Unknown %s: %q", formatParam, format)
+		return
+	}
+
 	return
 }
@@ -0,0 +1,137 @@
+/*
+Copyright 2015 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"math/rand"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+func TestGenerateJSONLogLine(t *testing.T) {
+	randomSource := rand.NewSource(1)
+
+	encoded := generateJSONLogLine(randomSource, 42, "GET", "/api/v1/namespaces/default/pods/foo", 200)
+
+	var line jsonLogLine
+	if err := json.Unmarshal([]byte(encoded), &line); err != nil {
+		t.Fatalf("generateJSONLogLine() produced invalid JSON: %v", err)
+	}
+	if line.ID != 42 {
+		t.Fatalf("line.ID = %d, want 42", line.ID)
+	}
+	if line.TraceID == "" {
+		t.Fatalf("line.TraceID is empty, want a generated trace ID")
+	}
+}
+
+var klogLinePattern = regexp.MustCompile(`^I\d{6}\.\d{6} \S+:\d+\] id=42 level=\S+ method=GET url=\S+ status=200 msg=".*" trace_id=[0-9a-f]+$`)
+
+func TestGenerateKlogLogLine(t *testing.T) {
+	randomSource := rand.NewSource(1)
+
+	line := generateKlogLogLine(randomSource, 42, "GET", "/api/v1/namespaces/default/pods/foo", 200)
+
+	if !klogLinePattern.MatchString(line) {
+		t.Fatalf("generateKlogLogLine() = %q, want it to match %s", line, klogLinePattern)
+	}
+}
+
+func TestGenerateTraceID(t *testing.T) {
+	randomSource := rand.NewSource(1)
+
+	traceID := generateTraceID(randomSource)
+
+	if len(traceID) != 32 {
+		t.Fatalf("len(generateTraceID()) = %d, want 32 (16 bytes hex-encoded)", len(traceID))
+	}
+	if !regexp.MustCompile(`^[0-9a-f]{32}$`).MatchString(traceID) {
+		t.Fatalf("generateTraceID() = %q, want a lowercase hex string", traceID)
+	}
+}
+
+func TestParseGenerateParameters(t *testing.T) {
+	tests := []struct {
+		name           string
+		query          string
+		wantLinesTotal int
+		wantDuration   int
+		wantFormat     logFormat
+		wantErr        bool
+	}{
+		{
+			name:           "defaults to plain format",
+			query:          "lines_total=10&duration=5s",
+			wantLinesTotal: 10,
+			wantDuration:   5,
+			wantFormat:     plainFormat,
+		},
+		{
+			name:           "explicit json format",
+			query:          "lines_total=10&duration=5s&format=json",
+			wantLinesTotal: 10,
+			wantDuration:   5,
+			wantFormat:     jsonFormat,
+		},
+		{
+			name:           "explicit klog format",
+			query:          "lines_total=10&duration=5s&format=klog",
+			wantLinesTotal: 10,
+			wantDuration:   5,
+			wantFormat:     klogFormat,
+		},
+		{
+			name:    "unknown format",
+			query:   "lines_total=10&duration=5s&format=xml",
+			wantErr: true,
+		},
+		{
+			name:    "missing lines_total",
+			query:   "duration=5s",
+			wantErr: true,
+		},
+		{
+			name:    "missing duration",
+			query:   "lines_total=10",
+			wantErr: true,
+		},
+		{
+			name:    "non-positive duration",
+			query:   "lines_total=10&duration=500ms",
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/generate?"+test.query, nil)
+
+			linesTotal, durationSeconds, format, err := parseGenerateParameters(req)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, test.wantErr)
+			}
+			if test.wantErr {
+				return
+			}
+			if linesTotal != test.wantLinesTotal || durationSeconds != test.wantDuration || format != test.wantFormat {
+				t.Fatalf("got (%d, %d, %q), want (%d, %d, %q)", linesTotal, durationSeconds, format, test.wantLinesTotal, test.wantDuration, test.wantFormat)
+			}
+		})
+	}
+}